@@ -0,0 +1,452 @@
+// This code is available on the terms of the project LICENSE.md file,
+// also available online at https://blueoakcouncil.org/license/1.0.0.
+
+package market
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/decred/dcrdex/dex/msgjson"
+	"github.com/decred/dcrdex/dex/order"
+	"github.com/decred/dcrdex/server/account"
+	"github.com/decred/dcrdex/server/matcher"
+)
+
+// handleBatch is the handler for the 'batch' route. This route accepts a
+// msgjson.Batch payload containing one or more limit, market, or cancel
+// entries, validates and submits each independently to its respective
+// MarketTunnel, and returns one msgjson.BatchResult per entry. An entry's
+// failure does not prevent the remaining entries from being processed.
+//
+// Because batch entries may reference overlapping UTXOs, each entry's
+// funding outpoints are reserved with the target MarketTunnel before
+// submission, so a later entry in the same batch cannot spend a UTXO an
+// earlier entry already claimed. If batch.CancelOnPartialFailure is set and
+// any entry fails, every order already queued by this batch is canceled.
+//
+// Each entry is rate-limited, and each entry carrying a ClientOrderID is
+// deduplicated, the same as if it had been submitted individually through
+// 'limit', 'market', or 'cancel'; otherwise a batch could be used to bypass
+// both protections.
+func (r *OrderRouter) handleBatch(user account.AccountID, msg *msgjson.Message) *msgjson.Error {
+	batch := new(msgjson.Batch)
+	err := json.Unmarshal(msg.Payload, batch)
+	if err != nil {
+		return msgjson.NewError(msgjson.RPCParseError, "error decoding 'batch' payload")
+	}
+	if len(batch.Orders) == 0 {
+		return msgjson.NewError(msgjson.OrderParameterError, "batch must contain at least one order")
+	}
+
+	results := make([]*msgjson.BatchResult, len(batch.Orders))
+	reserved := make(map[string]bool)
+	var queued []*batchQueuedOrder
+	var anyFailed bool
+
+	for i, entry := range batch.Orders {
+		oRecord, tunnel, idKey, cached, rpcErr := r.prepBatchEntry(user, entry, reserved)
+		if cached != nil {
+			results[i] = batchResultFromCached(cached)
+			continue
+		}
+		if rpcErr != nil {
+			if idKey != nil && !isTransientError(rpcErr) {
+				r.idempo.put(*idKey, &IdempotencyResult{Err: rpcErr})
+			}
+			results[i] = &msgjson.BatchResult{Error: rpcErr}
+			anyFailed = true
+			continue
+		}
+		tunnel.SubmitOrderAsync(oRecord)
+		queued = append(queued, &batchQueuedOrder{oRecord: oRecord, tunnel: tunnel})
+		res := r.signOrderResult(oRecord)
+		if idKey != nil {
+			r.idempo.put(*idKey, &IdempotencyResult{OrderID: oRecord.order.ID(), Result: res})
+		}
+		results[i] = &msgjson.BatchResult{OrderResult: res}
+	}
+
+	if anyFailed && batch.CancelOnPartialFailure {
+		r.cancelBatchEntries(user, queued)
+	}
+
+	respMsg, err := msgjson.NewResponse(msg.ID, results, nil)
+	if err != nil {
+		log.Errorf("failed to create msgjson.Message for batch response: %v", err)
+		return msgjson.NewError(msgjson.RPCInternalError, "internal error")
+	}
+	r.auth.Send(user, respMsg)
+	return nil
+}
+
+// batchQueuedOrder pairs a successfully submitted batch entry with the
+// MarketTunnel it was submitted to, so it can be canceled later if the batch
+// is rolled back.
+type batchQueuedOrder struct {
+	oRecord *orderRecord
+	tunnel  MarketTunnel
+}
+
+// prepBatchEntry validates a single entry of a 'batch' request and, on
+// success, returns an orderRecord ready for submission along with the
+// MarketTunnel it belongs to. It does not submit the order, cache the
+// outcome, or respond to the client; that is left to the caller so entries
+// can be aggregated into a single batch response.
+//
+// If the entry carries a ClientOrderID already present in the idempotency
+// cache, cached is returned and oRecord/tunnel/rpcErr are all nil; the caller
+// should use cached as-is. Otherwise, if the entry carries a ClientOrderID
+// not yet seen, idKey is returned so the caller can cache this entry's
+// eventual outcome.
+//
+// Each entry is also charged against the same per-account rate limiter as
+// the single-order routes, so a batch cannot be used to bypass either the
+// per-account submission limits or the ClientOrderID dedup.
+func (r *OrderRouter) prepBatchEntry(user account.AccountID, entry *msgjson.BatchOrder, reserved map[string]bool) (oRecord *orderRecord, tunnel MarketTunnel, idKey *IdempotencyKey, cached *IdempotencyResult, rpcErr *msgjson.Error) {
+	switch entry.OrderType {
+	case msgjson.LimitOrderNum:
+		limit := new(msgjson.Limit)
+		if err := json.Unmarshal(entry.Payload, limit); err != nil {
+			return nil, nil, nil, nil, msgjson.NewError(msgjson.RPCParseError, "error decoding batch 'limit' entry")
+		}
+		idKey, cached = r.checkBatchIdempotency(user, limit.ClientOrderID)
+		if cached != nil {
+			return nil, nil, nil, cached, nil
+		}
+		if rpcErr = r.limiter.checkOrder(user); rpcErr != nil {
+			return nil, nil, idKey, nil, rpcErr
+		}
+		oRecord, tunnel, rpcErr = r.prepBatchLimit(user, limit, reserved)
+		return oRecord, tunnel, idKey, nil, rpcErr
+	case msgjson.MarketOrderNum:
+		mkt := new(msgjson.Market)
+		if err := json.Unmarshal(entry.Payload, mkt); err != nil {
+			return nil, nil, nil, nil, msgjson.NewError(msgjson.RPCParseError, "error decoding batch 'market' entry")
+		}
+		idKey, cached = r.checkBatchIdempotency(user, mkt.ClientOrderID)
+		if cached != nil {
+			return nil, nil, nil, cached, nil
+		}
+		if rpcErr = r.limiter.checkOrder(user); rpcErr != nil {
+			return nil, nil, idKey, nil, rpcErr
+		}
+		oRecord, tunnel, rpcErr = r.prepBatchMarket(user, mkt, reserved)
+		return oRecord, tunnel, idKey, nil, rpcErr
+	case msgjson.CancelOrderNum:
+		cancel := new(msgjson.Cancel)
+		if err := json.Unmarshal(entry.Payload, cancel); err != nil {
+			return nil, nil, nil, nil, msgjson.NewError(msgjson.RPCParseError, "error decoding batch 'cancel' entry")
+		}
+		idKey, cached = r.checkBatchIdempotency(user, cancel.ClientOrderID)
+		if cached != nil {
+			return nil, nil, nil, cached, nil
+		}
+		if rpcErr = r.limiter.checkCancel(user); rpcErr != nil {
+			return nil, nil, idKey, nil, rpcErr
+		}
+		oRecord, tunnel, rpcErr = r.prepBatchCancel(user, cancel)
+		return oRecord, tunnel, idKey, nil, rpcErr
+	default:
+		return nil, nil, nil, nil, msgjson.NewError(msgjson.OrderParameterError, "unknown batch entry order type")
+	}
+}
+
+// checkBatchIdempotency looks up a cached result for a batch entry's
+// ClientOrderID, if it supplied one. A non-nil cached result means the
+// caller should use it as-is, without validating or submitting anything. A
+// non-nil key paired with a nil cached result means the caller owns caching
+// this entry's outcome once it is known.
+func (r *OrderRouter) checkBatchIdempotency(user account.AccountID, clientOrderID string) (*IdempotencyKey, *IdempotencyResult) {
+	if clientOrderID == "" {
+		return nil, nil
+	}
+	key := IdempotencyKey{AccountID: user, ClientOrderID: clientOrderID}
+	if cached, found := r.idempo.get(key); found {
+		return nil, cached
+	}
+	return &key, nil
+}
+
+// batchResultFromCached converts a cached IdempotencyResult into the
+// msgjson.BatchResult shape expected for a replayed batch entry.
+func batchResultFromCached(cached *IdempotencyResult) *msgjson.BatchResult {
+	if cached.Err != nil {
+		return &msgjson.BatchResult{Error: cached.Err}
+	}
+	return &msgjson.BatchResult{OrderResult: cached.Result}
+}
+
+// prepBatchLimit validates a 'limit' batch entry. It mirrors handleLimit,
+// reusing verifyAccount, extractMarketDetails, and checkPrefixTrade, but adds
+// the cross-entry UTXO reservation required for batch submission.
+func (r *OrderRouter) prepBatchLimit(user account.AccountID, limit *msgjson.Limit, reserved map[string]bool) (*orderRecord, MarketTunnel, *msgjson.Error) {
+	rpcErr := r.verifyAccount(user, limit.AccountID, limit)
+	if rpcErr != nil {
+		return nil, nil, rpcErr
+	}
+
+	tunnel, coins, sell, rpcErr := r.extractMarketDetails(&limit.Prefix, &limit.Trade)
+	if rpcErr != nil {
+		return nil, nil, rpcErr
+	}
+
+	if limit.OrderType != msgjson.LimitOrderNum {
+		return nil, nil, msgjson.NewError(msgjson.OrderParameterError, "wrong order type set for limit order")
+	}
+
+	rpcErr = r.claimBatchOutpoints(tunnel, &limit.Trade, reserved)
+	if rpcErr != nil {
+		return nil, nil, rpcErr
+	}
+
+	valSum, spendSize, utxos, rpcErr := r.checkPrefixTrade(user, tunnel, coins, &limit.Prefix, &limit.Trade, true)
+	if rpcErr != nil {
+		r.releaseBatchOutpoints(tunnel, &limit.Trade, reserved)
+		return nil, nil, rpcErr
+	}
+
+	if limit.Rate == 0 {
+		r.releaseBatchOutpoints(tunnel, &limit.Trade, reserved)
+		return nil, nil, msgjson.NewError(msgjson.OrderParameterError, "rate = 0 not allowed")
+	}
+	if limit.Rate%coins.quote.RateStep != 0 {
+		r.releaseBatchOutpoints(tunnel, &limit.Trade, reserved)
+		return nil, nil, msgjson.NewError(msgjson.OrderParameterError, "rate not a multiple of ratestep")
+	}
+
+	swapVal := limit.Quantity
+	if !sell {
+		swapVal = matcher.BaseToQuote(limit.Rate, limit.Quantity)
+	}
+	reqVal := requiredFunds(swapVal, spendSize, coins.funding)
+	if valSum < reqVal {
+		r.releaseBatchOutpoints(tunnel, &limit.Trade, reserved)
+		return nil, nil, msgjson.NewError(msgjson.FundingError,
+			fmt.Sprintf("not enough funds. need at least %d, got %d", reqVal, valSum))
+	}
+
+	if !(limit.TiF == msgjson.StandingOrderNum || limit.TiF == msgjson.ImmediateOrderNum) {
+		r.releaseBatchOutpoints(tunnel, &limit.Trade, reserved)
+		return nil, nil, msgjson.NewError(msgjson.OrderParameterError, "unknown time-in-force")
+	}
+
+	serverTime := time.Now().UTC()
+	lo := &order.LimitOrder{
+		MarketOrder: order.MarketOrder{
+			Prefix: order.Prefix{
+				AccountID:  user,
+				BaseAsset:  limit.Base,
+				QuoteAsset: limit.Quote,
+				OrderType:  order.LimitOrderType,
+				ClientTime: time.Unix(int64(limit.ClientTime), 0).UTC(),
+				ServerTime: serverTime,
+			},
+			UTXOs:    utxos,
+			Sell:     sell,
+			Quantity: limit.Quantity,
+			Address:  limit.Address,
+		},
+		Rate:  limit.Rate,
+		Force: order.StandingTiF,
+	}
+	return newOrderRecord(lo, limit, 0), tunnel, nil
+}
+
+// prepBatchMarket validates a 'market' batch entry. It mirrors handleMarket,
+// reusing verifyAccount, extractMarketDetails, and checkPrefixTrade, but adds
+// the cross-entry UTXO reservation required for batch submission.
+func (r *OrderRouter) prepBatchMarket(user account.AccountID, mkt *msgjson.Market, reserved map[string]bool) (*orderRecord, MarketTunnel, *msgjson.Error) {
+	rpcErr := r.verifyAccount(user, mkt.AccountID, mkt)
+	if rpcErr != nil {
+		return nil, nil, rpcErr
+	}
+
+	tunnel, coins, sell, rpcErr := r.extractMarketDetails(&mkt.Prefix, &mkt.Trade)
+	if rpcErr != nil {
+		return nil, nil, rpcErr
+	}
+
+	if mkt.OrderType != msgjson.MarketOrderNum {
+		return nil, nil, msgjson.NewError(msgjson.OrderParameterError, "wrong order type set for market order")
+	}
+
+	rpcErr = r.claimBatchOutpoints(tunnel, &mkt.Trade, reserved)
+	if rpcErr != nil {
+		return nil, nil, rpcErr
+	}
+
+	valSum, spendSize, utxos, rpcErr := r.checkPrefixTrade(user, tunnel, coins, &mkt.Prefix, &mkt.Trade, sell)
+	if rpcErr != nil {
+		r.releaseBatchOutpoints(tunnel, &mkt.Trade, reserved)
+		return nil, nil, rpcErr
+	}
+
+	var reqVal uint64
+	if sell {
+		reqVal = requiredFunds(mkt.Quantity, spendSize, coins.funding)
+	} else {
+		reqVal = matcher.QuoteToBase(tunnel.MidGap(), mkt.Quantity)
+		lotWithBuffer := uint64(float64(coins.base.LotSize) * r.mbBuffer)
+		minReq := matcher.QuoteToBase(tunnel.MidGap(), lotWithBuffer)
+		if reqVal < minReq {
+			r.releaseBatchOutpoints(tunnel, &mkt.Trade, reserved)
+			return nil, nil, msgjson.NewError(msgjson.FundingError, "order quantity does not satisfy market buy buffer")
+		}
+	}
+	if valSum < reqVal {
+		r.releaseBatchOutpoints(tunnel, &mkt.Trade, reserved)
+		return nil, nil, msgjson.NewError(msgjson.FundingError,
+			fmt.Sprintf("not enough funds. need at least %d, got %d", reqVal, valSum))
+	}
+
+	serverTime := time.Now().UTC()
+	mo := &order.MarketOrder{
+		Prefix: order.Prefix{
+			AccountID:  user,
+			BaseAsset:  mkt.Base,
+			QuoteAsset: mkt.Quote,
+			OrderType:  order.MarketOrderType,
+			ClientTime: time.Unix(int64(mkt.ClientTime), 0).UTC(),
+			ServerTime: serverTime,
+		},
+		UTXOs:    utxos,
+		Sell:     sell,
+		Quantity: mkt.Quantity,
+		Address:  mkt.Address,
+	}
+	return newOrderRecord(mo, mkt, 0), tunnel, nil
+}
+
+// prepBatchCancel validates a 'cancel' batch entry. It mirrors handleCancel,
+// reusing verifyAccount and extractMarket. Cancel entries do not fund or
+// claim any outpoints, so they are not subject to reservation.
+func (r *OrderRouter) prepBatchCancel(user account.AccountID, cancel *msgjson.Cancel) (*orderRecord, MarketTunnel, *msgjson.Error) {
+	rpcErr := r.verifyAccount(user, cancel.AccountID, cancel)
+	if rpcErr != nil {
+		return nil, nil, rpcErr
+	}
+
+	tunnel, rpcErr := r.extractMarket(&cancel.Prefix)
+	if rpcErr != nil {
+		return nil, nil, rpcErr
+	}
+
+	if len(cancel.TargetID) != order.OrderIDSize {
+		return nil, nil, msgjson.NewError(msgjson.OrderParameterError, "invalid target ID format")
+	}
+	var targetID order.OrderID
+	copy(targetID[:], cancel.TargetID)
+
+	if !tunnel.Cancelable(targetID) {
+		return nil, nil, msgjson.NewError(msgjson.OrderParameterError, "target order not known")
+	}
+
+	if cancel.OrderType != msgjson.CancelOrderNum {
+		return nil, nil, msgjson.NewError(msgjson.OrderParameterError, "wrong order type set for cancel order")
+	}
+
+	rpcErr = checkTimes(&cancel.Prefix)
+	if rpcErr != nil {
+		return nil, nil, rpcErr
+	}
+
+	serverTime := time.Now().UTC()
+	co := &order.CancelOrder{
+		Prefix: order.Prefix{
+			AccountID:  user,
+			BaseAsset:  cancel.Base,
+			QuoteAsset: cancel.Quote,
+			OrderType:  order.MarketOrderType,
+			ClientTime: time.Unix(int64(cancel.ClientTime), 0).UTC(),
+			ServerTime: serverTime,
+		},
+		TargetOrderID: targetID,
+	}
+	return newOrderRecord(co, cancel, 0), tunnel, nil
+}
+
+// claimBatchOutpoints reserves a trade's funding outpoints, first against
+// this batch's own reserved set to catch an intra-batch double-spend, then
+// against the MarketTunnel itself so that other concurrent requests see the
+// outpoints as locked immediately, not just once the order is submitted.
+func (r *OrderRouter) claimBatchOutpoints(tunnel MarketTunnel, trade *msgjson.Trade, reserved map[string]bool) *msgjson.Error {
+	for _, utxo := range trade.UTXOs {
+		key := fmt.Sprintf("%s:%d", utxo.TxID.String(), utxo.Vout)
+		if reserved[key] {
+			return msgjson.NewError(msgjson.FundingError,
+				fmt.Sprintf("utxo %s already spent by an earlier entry in this batch", key))
+		}
+	}
+	outpoints := outpointsFromTrade(trade)
+	if !tunnel.ReserveOutpoints(outpoints) {
+		return msgjson.NewError(msgjson.FundingError, "one or more utxos are locked or reserved")
+	}
+	for _, utxo := range trade.UTXOs {
+		key := fmt.Sprintf("%s:%d", utxo.TxID.String(), utxo.Vout)
+		reserved[key] = true
+	}
+	return nil
+}
+
+// releaseBatchOutpoints releases a trade's funding outpoints back to the
+// MarketTunnel and clears their keys from this batch's reserved set. It is
+// the counterpart to claimBatchOutpoints, and must be used for every release
+// of a batch-reserved outpoint: releasing from the tunnel alone without also
+// clearing reserved would leave the outpoint marked as claimed for the rest
+// of the batch even though it is once again free, wrongly rejecting any
+// later entry in the same batch that legitimately reuses it.
+func (r *OrderRouter) releaseBatchOutpoints(tunnel MarketTunnel, trade *msgjson.Trade, reserved map[string]bool) {
+	tunnel.ReleaseOutpoints(outpointsFromTrade(trade))
+	for _, utxo := range trade.UTXOs {
+		delete(reserved, fmt.Sprintf("%s:%d", utxo.TxID.String(), utxo.Vout))
+	}
+}
+
+// outpointsFromTrade builds the []order.Outpoint for a trade's UTXOs, for use
+// with MarketTunnel.ReserveOutpoints/ReleaseOutpoints.
+func outpointsFromTrade(trade *msgjson.Trade) []order.Outpoint {
+	outpoints := make([]order.Outpoint, 0, len(trade.UTXOs))
+	for _, utxo := range trade.UTXOs {
+		outpoints = append(outpoints, newOutpoint(utxo.TxID, utxo.Vout))
+	}
+	return outpoints
+}
+
+// cancelBatchEntries issues a best-effort cancel through each queued entry's
+// MarketTunnel. It is used to roll back the successful entries of a batch
+// when CancelOnPartialFailure is set and at least one entry failed.
+//
+// Unlike a client-submitted cancel, this one is never responded to, but its
+// orderRecord.req must still be a real msgjson.Cancel: every other call site
+// in this router treats req as non-nil and signs/stamps it, so a nil req
+// here would panic the first time that assumption is relied upon.
+func (r *OrderRouter) cancelBatchEntries(user account.AccountID, queued []*batchQueuedOrder) {
+	for _, q := range queued {
+		oid := q.oRecord.order.ID()
+		now := time.Now().UTC()
+		co := &order.CancelOrder{
+			Prefix: order.Prefix{
+				AccountID:  user,
+				BaseAsset:  q.oRecord.order.Base(),
+				QuoteAsset: q.oRecord.order.Quote(),
+				OrderType:  order.MarketOrderType,
+				ClientTime: now,
+				ServerTime: now,
+			},
+			TargetOrderID: oid,
+		}
+		cancel := &msgjson.Cancel{
+			Prefix: msgjson.Prefix{
+				AccountID:  user[:],
+				Base:       q.oRecord.order.Base(),
+				Quote:      q.oRecord.order.Quote(),
+				OrderType:  msgjson.CancelOrderNum,
+				ClientTime: uint64(now.Unix()),
+			},
+			TargetID: oid[:],
+		}
+		q.tunnel.SubmitOrderAsync(newOrderRecord(co, cancel, 0))
+	}
+}
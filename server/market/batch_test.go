@@ -0,0 +1,197 @@
+// This code is available on the terms of the project LICENSE.md file,
+// also available online at https://blueoakcouncil.org/license/1.0.0.
+
+package market
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/decred/dcrdex/dex"
+	"github.com/decred/dcrdex/dex/msgjson"
+	"github.com/decred/dcrdex/dex/order"
+	"github.com/decred/dcrdex/server/account"
+)
+
+// fakeAuthManager is a minimal AuthManager that skips real signature
+// verification and records the message handed to Send, so a handler's
+// response can be inspected directly instead of going over the wire.
+type fakeAuthManager struct {
+	sent []*msgjson.Message
+}
+
+func (f *fakeAuthManager) Route(string, func(account.AccountID, *msgjson.Message) *msgjson.Error) {}
+func (f *fakeAuthManager) Auth(account.AccountID, []byte, []byte) error                           { return nil }
+func (f *fakeAuthManager) Sign(...msgjson.Signable)                                               {}
+func (f *fakeAuthManager) Send(_ account.AccountID, msg *msgjson.Message) {
+	f.sent = append(f.sent, msg)
+}
+
+// fakeTunnel is a minimal MarketTunnel used to observe what the batch
+// rollback path submits, without needing a real market or asset backend.
+type fakeTunnel struct {
+	submitted []*orderRecord
+}
+
+func (f *fakeTunnel) SubmitOrderAsync(oRecord *orderRecord) {
+	f.submitted = append(f.submitted, oRecord)
+}
+func (f *fakeTunnel) MidGap() uint64                                   { return 0 }
+func (f *fakeTunnel) OutpointLocked(txid string, vout uint32) bool     { return false }
+func (f *fakeTunnel) Cancelable(order.OrderID) bool                    { return true }
+func (f *fakeTunnel) TxMonitored(account.AccountID, string) bool       { return false }
+func (f *fakeTunnel) ReserveOutpoints(outpoints []order.Outpoint) bool { return true }
+func (f *fakeTunnel) ReleaseOutpoints(outpoints []order.Outpoint)      {}
+func (f *fakeTunnel) OrderStatus(order.OrderID) (*OrderStatus, error)  { return nil, ErrUnknownOrder }
+func (f *fakeTunnel) LinkOrders(a, b order.OrderID) error              { return nil }
+
+// TestCancelBatchEntries_NonNilReq confirms that a rollback cancel built for
+// a queued batch entry carries a real, non-nil msgjson.Cancel as its req, not
+// the nil placeholder that used to be passed to newOrderRecord. Any code path
+// that stamps or signs this orderRecord the way the rest of the router does
+// would nil-deref panic otherwise.
+func TestCancelBatchEntries_NonNilReq(t *testing.T) {
+	var acctID account.AccountID
+	copy(acctID[:], []byte("test-account-0000000000000000000"))
+
+	now := time.Now().UTC()
+	lo := &order.LimitOrder{
+		MarketOrder: order.MarketOrder{
+			Prefix: order.Prefix{
+				AccountID:  acctID,
+				BaseAsset:  42,
+				QuoteAsset: 0,
+				OrderType:  order.LimitOrderType,
+				ClientTime: now,
+				ServerTime: now,
+			},
+			Sell:     true,
+			Quantity: 100000,
+			Address:  "addr",
+		},
+		Rate:  1000,
+		Force: order.StandingTiF,
+	}
+
+	tunnel := &fakeTunnel{}
+	queued := []*batchQueuedOrder{
+		{oRecord: newOrderRecord(lo, nil, 0), tunnel: tunnel},
+	}
+
+	router := &OrderRouter{}
+	router.cancelBatchEntries(acctID, queued)
+
+	if len(tunnel.submitted) != 1 {
+		t.Fatalf("expected 1 rollback cancel submitted, got %d", len(tunnel.submitted))
+	}
+	rollback := tunnel.submitted[0]
+	if rollback.req == nil {
+		t.Fatal("rollback orderRecord.req is nil; stamping/signing it later would panic")
+	}
+	if _, ok := rollback.req.(*msgjson.Cancel); !ok {
+		t.Fatalf("rollback orderRecord.req is %T, expected *msgjson.Cancel", rollback.req)
+	}
+
+	co, ok := rollback.order.(*order.CancelOrder)
+	if !ok {
+		t.Fatalf("rollback orderRecord.order is %T, expected *order.CancelOrder", rollback.order)
+	}
+	if co.TargetOrderID != lo.ID() {
+		t.Fatalf("rollback cancel targets %v, expected the queued order's ID %v", co.TargetOrderID, lo.ID())
+	}
+}
+
+// TestHandleBatch_PartialFailureRollback submits a batch of two cancel
+// entries, one valid and one malformed, with CancelOnPartialFailure set. It
+// confirms that the malformed entry's failure does not prevent the valid
+// entry from being processed, that the response's BatchResult slice lines up
+// with the request by index, and that the valid entry's queued cancel is
+// rolled back via a second submission to its tunnel.
+func TestHandleBatch_PartialFailureRollback(t *testing.T) {
+	user := testAccountID(5)
+	mktName, err := dex.MarketName(42, 0)
+	if err != nil {
+		t.Fatalf("dex.MarketName: %v", err)
+	}
+
+	tunnel := &fakeTunnel{}
+	auth := &fakeAuthManager{}
+	r := &OrderRouter{
+		auth:    auth,
+		tunnels: map[string]MarketTunnel{mktName: tunnel},
+		limiter: newOrderRateLimiter(0, 0, 0, 0),
+		idempo:  newIdempotencyCache(nil, time.Minute, 10),
+	}
+
+	var targetID order.OrderID
+	targetID[0] = 1
+
+	now := uint64(time.Now().Unix())
+	okCancel := &msgjson.Cancel{
+		Prefix: msgjson.Prefix{
+			AccountID:  user[:],
+			Base:       42,
+			Quote:      0,
+			OrderType:  msgjson.CancelOrderNum,
+			ClientTime: now,
+		},
+		TargetID: targetID[:],
+	}
+	badCancel := &msgjson.Cancel{
+		Prefix: msgjson.Prefix{
+			AccountID:  user[:],
+			Base:       42,
+			Quote:      0,
+			OrderType:  msgjson.CancelOrderNum,
+			ClientTime: now,
+		},
+		TargetID: []byte{0x01}, // wrong length
+	}
+
+	okPayload, _ := json.Marshal(okCancel)
+	badPayload, _ := json.Marshal(badCancel)
+	batch := &msgjson.Batch{
+		Orders: []*msgjson.BatchOrder{
+			{OrderType: msgjson.CancelOrderNum, Payload: okPayload},
+			{OrderType: msgjson.CancelOrderNum, Payload: badPayload},
+		},
+		CancelOnPartialFailure: true,
+	}
+	batchPayload, _ := json.Marshal(batch)
+	msg := &msgjson.Message{ID: 1, Payload: batchPayload}
+
+	if rpcErr := r.handleBatch(user, msg); rpcErr != nil {
+		t.Fatalf("unexpected top-level error: %v", rpcErr)
+	}
+
+	if len(auth.sent) != 1 {
+		t.Fatalf("expected exactly 1 response sent, got %d", len(auth.sent))
+	}
+	var respPayload msgjson.ResponsePayload
+	if err := json.Unmarshal(auth.sent[0].Payload, &respPayload); err != nil {
+		t.Fatalf("decoding response payload: %v", err)
+	}
+	var results []*msgjson.BatchResult
+	if err := json.Unmarshal(respPayload.Result, &results); err != nil {
+		t.Fatalf("decoding batch results: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(results))
+	}
+	if results[0].Error != nil {
+		t.Fatalf("expected entry 0 (valid cancel) to succeed, got error: %v", results[0].Error)
+	}
+	if results[0].OrderResult == nil {
+		t.Fatal("expected entry 0 to carry an OrderResult")
+	}
+	if results[1].Error == nil {
+		t.Fatal("expected entry 1 (malformed target) to fail")
+	}
+
+	// The one successful entry must have been rolled back: its tunnel sees
+	// the original cancel submission plus a rollback cancel.
+	if len(tunnel.submitted) != 2 {
+		t.Fatalf("expected 2 submissions to the tunnel (original + rollback), got %d", len(tunnel.submitted))
+	}
+}
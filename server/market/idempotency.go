@@ -0,0 +1,211 @@
+// This code is available on the terms of the project LICENSE.md file,
+// also available online at https://blueoakcouncil.org/license/1.0.0.
+
+package market
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/decred/dcrdex/dex/msgjson"
+	"github.com/decred/dcrdex/dex/order"
+	"github.com/decred/dcrdex/server/account"
+)
+
+// defaultIdempotencyTTL is how long a cached dedup entry is kept, absent an
+// explicit OrderRouterConfig.IdempotencyTTL. It should comfortably exceed an
+// order's maximum time on the books plus a client's retry window.
+const defaultIdempotencyTTL = 10 * time.Minute
+
+// defaultIdempotencyCacheSize bounds the in-memory dedup cache, absent an
+// explicit OrderRouterConfig.IdempotencyCacheSize.
+const defaultIdempotencyCacheSize = 100_000
+
+// IdempotencyKey identifies a single client-submitted limit, market, or
+// cancel request for deduplication. ClientOrderID is a nonce chosen by the
+// client, which it may safely resend on retry.
+type IdempotencyKey struct {
+	AccountID     account.AccountID
+	ClientOrderID string
+}
+
+// IdempotencyResult is the cached outcome for an IdempotencyKey: either the
+// OrderID and signed msgjson.OrderResult for a successfully queued order, a
+// signed msgjson.OCOResult for a successfully queued oco pair, or the
+// msgjson.Error that was returned instead. Exactly one of Result, OCOResult,
+// or Err is set.
+type IdempotencyResult struct {
+	OrderID   order.OrderID
+	Result    *msgjson.OrderResult
+	OCOResult *msgjson.OCOResult
+	Err       *msgjson.Error
+	Expiry    time.Time
+}
+
+// IdempotencyStore persists the ClientOrderID dedup mapping through a server
+// restart. Without it, a client retrying during crash recovery could have
+// its retry treated as a brand new order.
+type IdempotencyStore interface {
+	// Put durably records result under key.
+	Put(key IdempotencyKey, result *IdempotencyResult) error
+	// Load returns every entry left behind by a prior process, for
+	// populating the in-memory cache on startup. Already-expired entries may
+	// be omitted or included; the cache discards expired entries either way.
+	Load() (map[IdempotencyKey]*IdempotencyResult, error)
+	// Delete removes key's durable copy, e.g. once it has expired or been
+	// evicted from the in-memory cache.
+	Delete(key IdempotencyKey) error
+}
+
+// idempotencyCacheEntry is the value held in the LRU list for a single
+// dedup entry.
+type idempotencyCacheEntry struct {
+	key    IdempotencyKey
+	result *IdempotencyResult
+}
+
+// idempotencyCache is a bounded, TTL-expiring LRU of IdempotencyKey ->
+// IdempotencyResult, optionally backed by an IdempotencyStore so entries
+// survive a server restart.
+type idempotencyCache struct {
+	store    IdempotencyStore
+	ttl      time.Duration
+	capacity int
+
+	mtx     sync.Mutex
+	entries map[IdempotencyKey]*list.Element
+	lru     *list.List // most-recently-used at the front
+}
+
+// newIdempotencyCache is the constructor for an idempotencyCache. It loads
+// any unexpired entries a prior process left behind in store, if provided.
+func newIdempotencyCache(store IdempotencyStore, ttl time.Duration, capacity int) *idempotencyCache {
+	if ttl <= 0 {
+		ttl = defaultIdempotencyTTL
+	}
+	if capacity <= 0 {
+		capacity = defaultIdempotencyCacheSize
+	}
+	c := &idempotencyCache{
+		store:    store,
+		ttl:      ttl,
+		capacity: capacity,
+		entries:  make(map[IdempotencyKey]*list.Element),
+		lru:      list.New(),
+	}
+	if store == nil {
+		return c
+	}
+	loaded, err := store.Load()
+	if err != nil {
+		log.Errorf("failed to load idempotency store: %v", err)
+		return c
+	}
+	now := time.Now()
+	for key, result := range loaded {
+		if now.After(result.Expiry) {
+			continue
+		}
+		c.insert(key, result)
+	}
+	return c
+}
+
+// get returns the cached result for key, if any and not yet expired.
+func (c *idempotencyCache) get(key IdempotencyKey) (*IdempotencyResult, bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	elem, found := c.entries[key]
+	if !found {
+		return nil, false
+	}
+	entry := elem.Value.(*idempotencyCacheEntry)
+	if time.Now().After(entry.result.Expiry) {
+		c.removeElement(elem)
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return entry.result, true
+}
+
+// put records result for key, persisting it through the store if configured,
+// and evicts the least-recently-used entry if the cache is over capacity.
+func (c *idempotencyCache) put(key IdempotencyKey, result *IdempotencyResult) {
+	result.Expiry = time.Now().Add(c.ttl)
+	c.mtx.Lock()
+	c.insert(key, result)
+	c.mtx.Unlock()
+	if c.store != nil {
+		if err := c.store.Put(key, result); err != nil {
+			log.Errorf("failed to persist idempotency entry: %v", err)
+		}
+	}
+}
+
+// insert adds or updates an entry and evicts the LRU entry if the cache is
+// over capacity. The caller must hold c.mtx.
+func (c *idempotencyCache) insert(key IdempotencyKey, result *IdempotencyResult) {
+	if elem, found := c.entries[key]; found {
+		elem.Value.(*idempotencyCacheEntry).result = result
+		c.lru.MoveToFront(elem)
+		return
+	}
+	elem := c.lru.PushFront(&idempotencyCacheEntry{key: key, result: result})
+	c.entries[key] = elem
+	if c.lru.Len() > c.capacity {
+		c.removeElement(c.lru.Back())
+	}
+}
+
+// removeElement removes elem from the cache and, if backed by a store,
+// deletes its durable copy too. The caller must hold c.mtx.
+func (c *idempotencyCache) removeElement(elem *list.Element) {
+	entry := elem.Value.(*idempotencyCacheEntry)
+	c.lru.Remove(elem)
+	delete(c.entries, entry.key)
+	if c.store != nil {
+		if err := c.store.Delete(entry.key); err != nil {
+			log.Errorf("failed to delete idempotency entry: %v", err)
+		}
+	}
+}
+
+// cacheOnFailure is run via defer by the limit/market/cancel handlers when a
+// ClientOrderID was supplied. It caches *errPtr under key if the handler is
+// returning an error, so a client's retry gets the same error back instead
+// of re-running validation against possibly-changed state.
+//
+// Transient errors are excluded: a RateLimitError or ClockRangeError reflects
+// the state of the request at the moment it arrived, not a defect in the
+// request itself, so caching one would force a legitimate retry to replay
+// the same transient failure for the rest of the idempotency TTL instead of
+// being re-evaluated once the condition that caused it has passed.
+func (r *OrderRouter) cacheOnFailure(key *IdempotencyKey, errPtr **msgjson.Error) {
+	err := *errPtr
+	if err == nil || isTransientError(err) {
+		return
+	}
+	r.idempo.put(*key, &IdempotencyResult{Err: err})
+}
+
+// isTransientError reports whether err reflects a transient condition
+// (rate limiting, clock skew) rather than a problem with the request itself.
+func isTransientError(err *msgjson.Error) bool {
+	switch err.Code {
+	case msgjson.RateLimitError, msgjson.ClockRangeError:
+		return true
+	default:
+		return false
+	}
+}
+
+// sendCached replays a previously cached result for a retried
+// ClientOrderID, without re-validating or resubmitting the order.
+func (r *OrderRouter) sendCached(user account.AccountID, msgID uint64, cached *IdempotencyResult) *msgjson.Error {
+	if cached.Err != nil {
+		return cached.Err
+	}
+	r.sendOrderResult(msgID, user, cached.Result)
+	return nil
+}
@@ -0,0 +1,104 @@
+// This code is available on the terms of the project LICENSE.md file,
+// also available online at https://blueoakcouncil.org/license/1.0.0.
+
+package market
+
+import (
+	"testing"
+	"time"
+
+	"github.com/decred/dcrdex/dex/msgjson"
+)
+
+// TestIdempotencyCache_GetPut confirms that put/get round-trip a result and
+// that an unknown key reports not-found.
+func TestIdempotencyCache_GetPut(t *testing.T) {
+	c := newIdempotencyCache(nil, time.Minute, 10)
+	key := IdempotencyKey{AccountID: testAccountID(1), ClientOrderID: "abc"}
+
+	if _, found := c.get(key); found {
+		t.Fatal("expected a miss for a key that was never put")
+	}
+
+	c.put(key, &IdempotencyResult{OrderID: [32]byte{1}})
+	cached, found := c.get(key)
+	if !found {
+		t.Fatal("expected a hit immediately after put")
+	}
+	if cached.OrderID != ([32]byte{1}) {
+		t.Fatalf("got back a different OrderID than was put: %v", cached.OrderID)
+	}
+}
+
+// TestIdempotencyCache_Expiry confirms that an entry older than its TTL is
+// treated as a miss and removed.
+func TestIdempotencyCache_Expiry(t *testing.T) {
+	c := newIdempotencyCache(nil, time.Minute, 10)
+	key := IdempotencyKey{AccountID: testAccountID(2), ClientOrderID: "xyz"}
+
+	c.put(key, &IdempotencyResult{})
+	// put() stamps Expiry ttl from now; force it into the past directly
+	// rather than waiting out a real TTL.
+	c.mtx.Lock()
+	c.entries[key].Value.(*idempotencyCacheEntry).result.Expiry = time.Now().Add(-time.Second)
+	c.mtx.Unlock()
+
+	if _, found := c.get(key); found {
+		t.Fatal("expected an expired entry to be reported as a miss")
+	}
+	if _, found := c.entries[key]; found {
+		t.Fatal("expected an expired entry to be removed from the cache on get")
+	}
+}
+
+// TestIdempotencyCache_LRUEviction confirms that exceeding capacity evicts
+// the least-recently-used entry, not an arbitrary one.
+func TestIdempotencyCache_LRUEviction(t *testing.T) {
+	c := newIdempotencyCache(nil, time.Minute, 2)
+	keyA := IdempotencyKey{AccountID: testAccountID(3), ClientOrderID: "a"}
+	keyB := IdempotencyKey{AccountID: testAccountID(3), ClientOrderID: "b"}
+	keyC := IdempotencyKey{AccountID: testAccountID(3), ClientOrderID: "c"}
+
+	c.put(keyA, &IdempotencyResult{})
+	c.put(keyB, &IdempotencyResult{})
+	// Touch A so B becomes the least-recently-used entry.
+	if _, found := c.get(keyA); !found {
+		t.Fatal("expected keyA to be present before eviction")
+	}
+	c.put(keyC, &IdempotencyResult{})
+
+	if _, found := c.get(keyB); found {
+		t.Fatal("expected keyB, the least-recently-used entry, to be evicted")
+	}
+	if _, found := c.get(keyA); !found {
+		t.Fatal("expected keyA to survive eviction, it was touched more recently")
+	}
+	if _, found := c.get(keyC); !found {
+		t.Fatal("expected keyC, just inserted, to be present")
+	}
+}
+
+// TestCacheOnFailure_SkipsTransientErrors confirms that cacheOnFailure does
+// not cache a transient RateLimitError or ClockRangeError, but does cache
+// any other error.
+func TestCacheOnFailure_SkipsTransientErrors(t *testing.T) {
+	r := &OrderRouter{idempo: newIdempotencyCache(nil, time.Minute, 10)}
+
+	key := IdempotencyKey{AccountID: testAccountID(4), ClientOrderID: "rl"}
+	rpcErr := msgjson.NewError(msgjson.RateLimitError, "rate limit exceeded")
+	r.cacheOnFailure(&key, &rpcErr)
+	if _, found := r.idempo.get(key); found {
+		t.Fatal("a transient RateLimitError should not be cached")
+	}
+
+	key2 := IdempotencyKey{AccountID: testAccountID(4), ClientOrderID: "param"}
+	rpcErr2 := msgjson.NewError(msgjson.OrderParameterError, "bad request")
+	r.cacheOnFailure(&key2, &rpcErr2)
+	cached, found := r.idempo.get(key2)
+	if !found {
+		t.Fatal("a non-transient error should be cached")
+	}
+	if cached.Err != rpcErr2 {
+		t.Fatal("cached error does not match the one passed to cacheOnFailure")
+	}
+}
@@ -0,0 +1,243 @@
+// This code is available on the terms of the project LICENSE.md file,
+// also available online at https://blueoakcouncil.org/license/1.0.0.
+
+package market
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/decred/dcrdex/dex/msgjson"
+	"github.com/decred/dcrdex/dex/order"
+	"github.com/decred/dcrdex/server/account"
+	"github.com/decred/dcrdex/server/matcher"
+)
+
+// handleOCO is the handler for the 'oco' route. This route accepts a
+// msgjson.OCO payload describing two linked limit order legs that share one
+// set of funding UTXOs, typically a take-profit leg above the current
+// mid-gap rate and a stop-style leg below it. Both legs are validated and
+// queued, then linked with their shared MarketTunnel so that a match on
+// either leg, even a partial one, automatically cancels the other.
+//
+// Because both legs reference the same funding UTXOs, the UTXOs are
+// resolved and their value summed only once, against leg A; leg B's UTXOs are
+// not looked up again, though its own order type, address, quantity, rate,
+// time-in-force and timestamps are still checked, and it funds from that
+// same set rather than a second one. The shared UTXOs are reserved with the
+// MarketTunnel for the lifetime of the pair, the same as a batch entry's
+// funding is reserved, so neither leg's UTXOs can be claimed by an unrelated
+// order while the pair is being built. Guarding against a genuine same-epoch
+// double-match of both legs is left to the MarketTunnel's LinkOrders
+// implementation, which is required to cancel a linked order's sibling as
+// soon as either one matches.
+func (r *OrderRouter) handleOCO(user account.AccountID, msg *msgjson.Message) (rpcErr *msgjson.Error) {
+	oco := new(msgjson.OCO)
+	if err := json.Unmarshal(msg.Payload, oco); err != nil {
+		return msgjson.NewError(msgjson.RPCParseError, "error decoding 'oco' payload")
+	}
+	if oco.LegA == nil || oco.LegB == nil {
+		return msgjson.NewError(msgjson.OrderParameterError, "oco requires two legs")
+	}
+	if !sameUTXOSet(oco.LegA.Trade.UTXOs, oco.LegB.Trade.UTXOs) {
+		return msgjson.NewError(msgjson.OrderParameterError, "oco legs must share the same funding utxos")
+	}
+
+	// The pair is deduplicated as a unit, keyed off leg A's ClientOrderID:
+	// a client retrying the same oco submission gets back the same linked
+	// pair instead of a second one sharing its already-claimed funding utxos.
+	var idempoKey *IdempotencyKey
+	if oco.LegA.ClientOrderID != "" {
+		key := IdempotencyKey{AccountID: user, ClientOrderID: oco.LegA.ClientOrderID}
+		if cached, found := r.idempo.get(key); found {
+			if cached.Err != nil {
+				return cached.Err
+			}
+			respMsg, err := msgjson.NewResponse(msg.ID, cached.OCOResult, nil)
+			if err != nil {
+				log.Errorf("failed to create msgjson.Message for cached oco response: %v", err)
+				return msgjson.NewError(msgjson.RPCInternalError, "internal error")
+			}
+			r.auth.Send(user, respMsg)
+			return nil
+		}
+		idempoKey = &key
+		defer r.cacheOnFailure(idempoKey, &rpcErr)
+	}
+
+	// An oco submits two orders, so it is charged against the per-account
+	// rate limiter for two tokens, the same as submitting each leg
+	// individually would be. The two tokens are reserved atomically: with two
+	// independent single-token reservations the first could succeed and the
+	// second be rejected, spending one token on an oco that was refused. The
+	// tokens are not returned if the oco is later rejected for another reason.
+	if rpcErr = r.limiter.checkOrderN(user, 2); rpcErr != nil {
+		return rpcErr
+	}
+
+	rpcErr = r.verifyAccount(user, oco.LegA.AccountID, oco.LegA)
+	if rpcErr != nil {
+		return rpcErr
+	}
+	rpcErr = r.verifyAccount(user, oco.LegB.AccountID, oco.LegB)
+	if rpcErr != nil {
+		return rpcErr
+	}
+
+	tunnel, coins, sell, rpcErr := r.extractMarketDetails(&oco.LegA.Prefix, &oco.LegA.Trade)
+	if rpcErr != nil {
+		return rpcErr
+	}
+	tunnelB, _, sellB, rpcErr := r.extractMarketDetails(&oco.LegB.Prefix, &oco.LegB.Trade)
+	if rpcErr != nil {
+		return rpcErr
+	}
+	if tunnel != tunnelB {
+		return msgjson.NewError(msgjson.OrderParameterError, "oco legs must be on the same market")
+	}
+	if sell != sellB {
+		return msgjson.NewError(msgjson.OrderParameterError, "oco legs must be on the same side")
+	}
+
+	// Reserve the shared funding utxos with the tunnel before building either
+	// leg, so a concurrent order cannot claim them out from under this pair
+	// while it's being validated and queued. claimBatchOutpoints is reused
+	// here with a throwaway reserved map, since an oco pair has no other
+	// entries to dedup against.
+	if rpcErr = r.claimBatchOutpoints(tunnel, &oco.LegA.Trade, make(map[string]bool)); rpcErr != nil {
+		return rpcErr
+	}
+	ocoOutpoints := outpointsFromTrade(&oco.LegA.Trade)
+
+	valSum, spendSize, utxos, rpcErr := r.checkPrefixTrade(user, tunnel, coins, &oco.LegA.Prefix, &oco.LegA.Trade, true)
+	if rpcErr != nil {
+		tunnel.ReleaseOutpoints(ocoOutpoints)
+		return rpcErr
+	}
+
+	loA, rpcErr := r.buildOCOLeg(user, oco.LegA, coins, sell, utxos)
+	if rpcErr != nil {
+		tunnel.ReleaseOutpoints(ocoOutpoints)
+		return rpcErr
+	}
+	loB, rpcErr := r.buildOCOLeg(user, oco.LegB, coins, sell, utxos)
+	if rpcErr != nil {
+		tunnel.ReleaseOutpoints(ocoOutpoints)
+		return rpcErr
+	}
+
+	// Either leg may end up being the one that matches, so the shared utxos
+	// must cover whichever leg would require the larger swap value, not just
+	// leg A's.
+	swapValA := loA.Quantity
+	swapValB := loB.Quantity
+	if !sell {
+		swapValA = matcher.BaseToQuote(loA.Rate, loA.Quantity)
+		swapValB = matcher.BaseToQuote(loB.Rate, loB.Quantity)
+	}
+	reqValA := requiredFunds(swapValA, spendSize, coins.funding)
+	reqValB := requiredFunds(swapValB, spendSize, coins.funding)
+	reqVal := reqValA
+	if reqValB > reqVal {
+		reqVal = reqValB
+	}
+	if valSum < reqVal {
+		tunnel.ReleaseOutpoints(ocoOutpoints)
+		return msgjson.NewError(msgjson.FundingError,
+			fmt.Sprintf("not enough funds. need at least %d, got %d", reqVal, valSum))
+	}
+
+	if err := tunnel.LinkOrders(loA.ID(), loB.ID()); err != nil {
+		tunnel.ReleaseOutpoints(ocoOutpoints)
+		return msgjson.NewError(msgjson.OrderParameterError, "failed to link oco legs: "+err.Error())
+	}
+
+	recordA := newOrderRecord(loA, oco.LegA, 0)
+	recordB := newOrderRecord(loB, oco.LegB, 0)
+	tunnel.SubmitOrderAsync(recordA)
+	tunnel.SubmitOrderAsync(recordB)
+
+	res := &msgjson.OCOResult{
+		LegA: r.signOrderResult(recordA),
+		LegB: r.signOrderResult(recordB),
+	}
+	if idempoKey != nil {
+		r.idempo.put(*idempoKey, &IdempotencyResult{OCOResult: res})
+	}
+	respMsg, err := msgjson.NewResponse(msg.ID, res, nil)
+	if err != nil {
+		log.Errorf("failed to create msgjson.Message for oco response: %v", err)
+		return msgjson.NewError(msgjson.RPCInternalError, "internal error")
+	}
+	r.auth.Send(user, respMsg)
+	return nil
+}
+
+// buildOCOLeg validates one leg's address, quantity, rate, and
+// time-in-force, and constructs its order.LimitOrder. The shared UTXOs
+// themselves have already been validated and reserved against leg A by the
+// caller, so utxos is attached directly rather than re-derived; this leg's
+// own address and quantity are not covered by that shared check and so are
+// validated here the same way checkPrefixTrade would.
+func (r *OrderRouter) buildOCOLeg(user account.AccountID, leg *msgjson.Limit, coins *assetSet, sell bool, utxos []order.Outpoint) (*order.LimitOrder, *msgjson.Error) {
+	if leg.OrderType != msgjson.LimitOrderNum {
+		return nil, msgjson.NewError(msgjson.OrderParameterError, "wrong order type set for oco leg")
+	}
+	if rpcErr := r.checkTradeQuantity(coins, &leg.Trade, true); rpcErr != nil {
+		return nil, rpcErr
+	}
+	if leg.Rate == 0 {
+		return nil, msgjson.NewError(msgjson.OrderParameterError, "rate = 0 not allowed")
+	}
+	if leg.Rate%coins.quote.RateStep != 0 {
+		return nil, msgjson.NewError(msgjson.OrderParameterError, "rate not a multiple of ratestep")
+	}
+	if !(leg.TiF == msgjson.StandingOrderNum || leg.TiF == msgjson.ImmediateOrderNum) {
+		return nil, msgjson.NewError(msgjson.OrderParameterError, "unknown time-in-force")
+	}
+	rpcErr := checkTimes(&leg.Prefix)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+
+	serverTime := time.Now().UTC()
+	return &order.LimitOrder{
+		MarketOrder: order.MarketOrder{
+			Prefix: order.Prefix{
+				AccountID:  user,
+				BaseAsset:  leg.Base,
+				QuoteAsset: leg.Quote,
+				OrderType:  order.LimitOrderType,
+				ClientTime: time.Unix(int64(leg.ClientTime), 0).UTC(),
+				ServerTime: serverTime,
+			},
+			UTXOs:    utxos,
+			Sell:     sell,
+			Quantity: leg.Quantity,
+			Address:  leg.Address,
+		},
+		Rate:  leg.Rate,
+		Force: order.StandingTiF,
+	}, nil
+}
+
+// sameUTXOSet reports whether a and b reference the same set of outpoints,
+// regardless of order.
+func sameUTXOSet(a, b []msgjson.UTXO) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	seen := make(map[string]int, len(a))
+	for _, utxo := range a {
+		seen[fmt.Sprintf("%s:%d", utxo.TxID.String(), utxo.Vout)]++
+	}
+	for _, utxo := range b {
+		key := fmt.Sprintf("%s:%d", utxo.TxID.String(), utxo.Vout)
+		if seen[key] == 0 {
+			return false
+		}
+		seen[key]--
+	}
+	return true
+}
@@ -5,6 +5,7 @@ package market
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"time"
@@ -55,6 +56,26 @@ type MarketTunnel interface {
 	// involved in a DEX-monitored trade. Change outputs from DEX-monitored trades
 	// can be used in other orders without waiting for fundConf confirmations.
 	TxMonitored(user account.AccountID, txid string) bool
+	// ReserveOutpoints attempts to claim the given outpoints for exclusive use
+	// by an in-flight batch submission, returning false if any outpoint is
+	// already locked by an existing order or reserved by a concurrent batch.
+	// A successful reservation must eventually be followed by either the
+	// outpoints becoming locked by a submitted order, or a matching call to
+	// ReleaseOutpoints.
+	ReserveOutpoints(outpoints []order.Outpoint) bool
+	// ReleaseOutpoints releases outpoints previously claimed with
+	// ReserveOutpoints that were not ultimately submitted as part of an order.
+	ReleaseOutpoints(outpoints []order.Outpoint)
+	// OrderStatus returns the current status of a previously submitted order,
+	// or ErrUnknownOrder if this MarketTunnel has no record of it.
+	OrderStatus(order.OrderID) (*OrderStatus, error)
+	// LinkOrders registers a and b as an OCO (one-cancels-other) pair, such
+	// that a partial or full match of either leg triggers automatic
+	// cancellation of its sibling. Because both legs may be in the same
+	// epoch queue, the implementation must treat a same-epoch match of both
+	// legs as the same case: whichever leg matches first wins, and the
+	// other is canceled before it can also match.
+	LinkOrders(a, b order.OrderID) error
 }
 
 // orderRecord contains the information necessary to respond to an order
@@ -124,6 +145,8 @@ type OrderRouter struct {
 	assets   map[uint32]*asset.Asset
 	tunnels  map[string]MarketTunnel
 	mbBuffer float64
+	limiter  *orderRateLimiter
+	idempo   *idempotencyCache
 }
 
 // OrderRouterConfig is the configuration settings for an OrderRouter.
@@ -132,6 +155,27 @@ type OrderRouterConfig struct {
 	Assets          map[uint32]*asset.Asset
 	Markets         map[string]MarketTunnel
 	MarketBuyBuffer float64
+	// OrdersPerSecond and OrderBurst configure the per-account token-bucket
+	// limit on limit/market order submissions. A non-positive
+	// OrdersPerSecond disables order rate limiting.
+	OrdersPerSecond float64
+	OrderBurst      int
+	// CancelsPerSecond and CancelBurst configure the per-account token-bucket
+	// limit on cancel submissions. A non-positive CancelsPerSecond disables
+	// cancel rate limiting.
+	CancelsPerSecond float64
+	CancelBurst      int
+	// IdempotencyStore, if non-nil, persists the ClientOrderID dedup mapping
+	// so it survives a server restart. If nil, dedup is still performed but
+	// only for the lifetime of the process.
+	IdempotencyStore IdempotencyStore
+	// IdempotencyTTL is how long a cached ClientOrderID result is retained.
+	// It should exceed the order's maximum time on the books plus a grace
+	// period for client retries. Defaults to defaultIdempotencyTTL.
+	IdempotencyTTL time.Duration
+	// IdempotencyCacheSize bounds the number of in-memory dedup entries.
+	// Defaults to defaultIdempotencyCacheSize.
+	IdempotencyCacheSize int
 }
 
 // NewOrderRouter is a constructor for an OrderRouter.
@@ -141,24 +185,52 @@ func NewOrderRouter(cfg *OrderRouterConfig) *OrderRouter {
 		assets:   cfg.Assets,
 		tunnels:  cfg.Markets,
 		mbBuffer: cfg.MarketBuyBuffer,
+		limiter: newOrderRateLimiter(
+			cfg.OrdersPerSecond, cfg.OrderBurst,
+			cfg.CancelsPerSecond, cfg.CancelBurst,
+		),
+		idempo: newIdempotencyCache(cfg.IdempotencyStore, cfg.IdempotencyTTL, cfg.IdempotencyCacheSize),
 	}
 	cfg.AuthManager.Route(msgjson.LimitRoute, router.handleLimit)
 	cfg.AuthManager.Route(msgjson.MarketRoute, router.handleMarket)
 	cfg.AuthManager.Route(msgjson.CancelRoute, router.handleCancel)
+	cfg.AuthManager.Route(msgjson.BatchRoute, router.handleBatch)
+	cfg.AuthManager.Route(msgjson.OrderStatusRoute, router.handleOrderStatus)
+	cfg.AuthManager.Route(msgjson.OCORoute, router.handleOCO)
 	return router
 }
 
+// Run starts the OrderRouter's background maintenance, currently just the
+// rate limiter's idle-limiter janitor, and blocks until ctx is canceled.
+func (r *OrderRouter) Run(ctx context.Context) {
+	r.limiter.runJanitor(ctx)
+}
+
 // handleLimit is the handler for the 'limit' route. This route accepts a
 // msgjson.Limit payload, validates the information, constructs an
 // order.LimitOrder and submits it to the epoch queue.
-func (r *OrderRouter) handleLimit(user account.AccountID, msg *msgjson.Message) *msgjson.Error {
+func (r *OrderRouter) handleLimit(user account.AccountID, msg *msgjson.Message) (rpcErr *msgjson.Error) {
 	limit := new(msgjson.Limit)
 	err := json.Unmarshal(msg.Payload, limit)
 	if err != nil {
 		return msgjson.NewError(msgjson.RPCParseError, "error decoding 'limit' payload")
 	}
 
-	rpcErr := r.verifyAccount(user, limit.AccountID, limit)
+	var idempoKey *IdempotencyKey
+	if limit.ClientOrderID != "" {
+		key := IdempotencyKey{AccountID: user, ClientOrderID: limit.ClientOrderID}
+		if cached, found := r.idempo.get(key); found {
+			return r.sendCached(user, msg.ID, cached)
+		}
+		idempoKey = &key
+		defer r.cacheOnFailure(idempoKey, &rpcErr)
+	}
+
+	if rpcErr = r.limiter.checkOrder(user); rpcErr != nil {
+		return rpcErr
+	}
+
+	rpcErr = r.verifyAccount(user, limit.AccountID, limit)
 	if rpcErr != nil {
 		return rpcErr
 	}
@@ -230,21 +302,39 @@ func (r *OrderRouter) handleLimit(user account.AccountID, msg *msgjson.Message)
 		msgID: msg.ID,
 	}
 	tunnel.SubmitOrderAsync(oRecord)
-	r.respondOrder(oRecord)
+	res := r.signOrderResult(oRecord)
+	if idempoKey != nil {
+		r.idempo.put(*idempoKey, &IdempotencyResult{OrderID: oRecord.order.ID(), Result: res})
+	}
+	r.sendOrderResult(oRecord.msgID, oRecord.order.User(), res)
 	return nil
 }
 
 // handleMarket is the handler for the 'market' route. This route accepts a
 // msgjson.Market payload, validates the information, constructs an
 // order.MarketOrder and submits it to the epoch queue.
-func (r *OrderRouter) handleMarket(user account.AccountID, msg *msgjson.Message) *msgjson.Error {
+func (r *OrderRouter) handleMarket(user account.AccountID, msg *msgjson.Message) (rpcErr *msgjson.Error) {
 	market := new(msgjson.Market)
 	err := json.Unmarshal(msg.Payload, market)
 	if err != nil {
 		return msgjson.NewError(msgjson.RPCParseError, "error decoding 'market' payload")
 	}
 
-	rpcErr := r.verifyAccount(user, market.AccountID, market)
+	var idempoKey *IdempotencyKey
+	if market.ClientOrderID != "" {
+		key := IdempotencyKey{AccountID: user, ClientOrderID: market.ClientOrderID}
+		if cached, found := r.idempo.get(key); found {
+			return r.sendCached(user, msg.ID, cached)
+		}
+		idempoKey = &key
+		defer r.cacheOnFailure(idempoKey, &rpcErr)
+	}
+
+	if rpcErr = r.limiter.checkOrder(user); rpcErr != nil {
+		return rpcErr
+	}
+
+	rpcErr = r.verifyAccount(user, market.AccountID, market)
 	if rpcErr != nil {
 		return rpcErr
 	}
@@ -309,21 +399,39 @@ func (r *OrderRouter) handleMarket(user account.AccountID, msg *msgjson.Message)
 		msgID: msg.ID,
 	}
 	tunnel.SubmitOrderAsync(oRecord)
-	r.respondOrder(oRecord)
+	res := r.signOrderResult(oRecord)
+	if idempoKey != nil {
+		r.idempo.put(*idempoKey, &IdempotencyResult{OrderID: oRecord.order.ID(), Result: res})
+	}
+	r.sendOrderResult(oRecord.msgID, oRecord.order.User(), res)
 	return nil
 }
 
 // handleCancel is the handler for the 'cancel' route. This route accepts a
 // msgjson.Cancel payload, validates the information, constructs an
 // order.CancelOrder and submits it to the epoch queue.
-func (r *OrderRouter) handleCancel(user account.AccountID, msg *msgjson.Message) *msgjson.Error {
+func (r *OrderRouter) handleCancel(user account.AccountID, msg *msgjson.Message) (rpcErr *msgjson.Error) {
 	cancel := new(msgjson.Cancel)
 	err := json.Unmarshal(msg.Payload, cancel)
 	if err != nil {
 		return msgjson.NewError(msgjson.RPCParseError, "error decoding 'cancel' payload")
 	}
 
-	rpcErr := r.verifyAccount(user, cancel.AccountID, cancel)
+	var idempoKey *IdempotencyKey
+	if cancel.ClientOrderID != "" {
+		key := IdempotencyKey{AccountID: user, ClientOrderID: cancel.ClientOrderID}
+		if cached, found := r.idempo.get(key); found {
+			return r.sendCached(user, msg.ID, cached)
+		}
+		idempoKey = &key
+		defer r.cacheOnFailure(idempoKey, &rpcErr)
+	}
+
+	if rpcErr = r.limiter.checkCancel(user); rpcErr != nil {
+		return rpcErr
+	}
+
+	rpcErr = r.verifyAccount(user, cancel.AccountID, cancel)
 	if rpcErr != nil {
 		return rpcErr
 	}
@@ -374,7 +482,11 @@ func (r *OrderRouter) handleCancel(user account.AccountID, msg *msgjson.Message)
 		msgID: msg.ID,
 	}
 	tunnel.SubmitOrderAsync(oRecord)
-	r.respondOrder(oRecord)
+	res := r.signOrderResult(oRecord)
+	if idempoKey != nil {
+		r.idempo.put(*idempoKey, &IdempotencyResult{OrderID: oRecord.order.ID(), Result: res})
+	}
+	r.sendOrderResult(oRecord.msgID, oRecord.order.User(), res)
 	return nil
 }
 
@@ -456,6 +568,25 @@ func checkTimes(prefix *msgjson.Prefix) *msgjson.Error {
 	return nil
 }
 
+// checkTradeQuantity validates a trade's address and quantity: the address is
+// checked against the receiving asset's backend, and the quantity must be
+// non-zero and, if checkLot is set, an integral multiple of the lot size.
+// It is split out of checkPrefixTrade so a caller that shares another trade's
+// already-validated UTXOs, such as buildOCOLeg for an oco pair's second leg,
+// can still validate its own address and quantity without re-deriving utxos.
+func (r *OrderRouter) checkTradeQuantity(coins *assetSet, trade *msgjson.Trade, checkLot bool) *msgjson.Error {
+	if !coins.receiving.Backend.CheckAddress(trade.Address) {
+		return msgjson.NewError(msgjson.OrderParameterError, "address doesn't check")
+	}
+	if trade.Quantity == 0 {
+		return msgjson.NewError(msgjson.OrderParameterError, "zero quantity not allowed")
+	}
+	if checkLot && trade.Quantity%coins.base.LotSize != 0 {
+		return msgjson.NewError(msgjson.OrderParameterError, "order quantity not a multiple of lot size")
+	}
+	return nil
+}
+
 // checkPrefixTrade validates the information in the prefix and trade portions
 // of an order.
 func (r *OrderRouter) checkPrefixTrade(user account.AccountID, tunnel MarketTunnel, coins *assetSet, prefix *msgjson.Prefix,
@@ -468,16 +599,10 @@ func (r *OrderRouter) checkPrefixTrade(user account.AccountID, tunnel MarketTunn
 	errSet := func(code int, message string) (uint64, uint32, []order.Outpoint, *msgjson.Error) {
 		return 0, 0, nil, msgjson.NewError(code, message)
 	}
-	// Check that the address is valid.
-	if !coins.receiving.Backend.CheckAddress(trade.Address) {
-		return errSet(msgjson.OrderParameterError, "address doesn't check")
-	}
-	// Quantity cannot be zero, and must be an integral multiple of the lot size.
-	if trade.Quantity == 0 {
-		return errSet(msgjson.OrderParameterError, "zero quantity not allowed")
-	}
-	if checkLot && trade.Quantity%coins.base.LotSize != 0 {
-		return errSet(msgjson.OrderParameterError, "order quantity not a multiple of lot size")
+	// Check that the address is valid, and that the quantity is non-zero and
+	// a multiple of the lot size.
+	if rpcErr := r.checkTradeQuantity(coins, trade, checkLot); rpcErr != nil {
+		return 0, 0, nil, rpcErr
 	}
 	// Validate UTXOs
 	// Check that all required arrays are of equal length.
@@ -555,23 +680,36 @@ func msgBytesToBytes(msgBs []msgjson.Bytes) [][]byte {
 	return b
 }
 
-// respondOrder signs the order data and sends the OrderResult to the client.
-func (r *OrderRouter) respondOrder(oRecord *orderRecord) {
+// signOrderResult adds the server timestamp to oRecord's request, signs it,
+// and builds the resulting msgjson.OrderResult. This is the common step
+// shared by the single-order routes and the batch route.
+func (r *OrderRouter) signOrderResult(oRecord *orderRecord) *msgjson.OrderResult {
 	// Add the server timestamp and get a signature of the serialized
 	// order request to send to the client.
 	stamp := uint64(oRecord.order.Time())
 	oRecord.req.Stamp(stamp)
 	oid := oRecord.order.ID()
 	r.auth.Sign(oRecord.req)
-	res := &msgjson.OrderResult{
+	return &msgjson.OrderResult{
 		Sig:        oRecord.req.SigBytes(),
 		ServerTime: stamp,
 		OrderID:    oid[:],
 	}
-	respMsg, err := msgjson.NewResponse(oRecord.msgID, res, nil)
+}
+
+// respondOrder signs the order data and sends the OrderResult to the client.
+func (r *OrderRouter) respondOrder(oRecord *orderRecord) {
+	res := r.signOrderResult(oRecord)
+	r.sendOrderResult(oRecord.msgID, oRecord.order.User(), res)
+}
+
+// sendOrderResult wraps a signed msgjson.OrderResult in a response to msgID
+// and sends it to user.
+func (r *OrderRouter) sendOrderResult(msgID uint64, user account.AccountID, res *msgjson.OrderResult) {
+	respMsg, err := msgjson.NewResponse(msgID, res, nil)
 	if err != nil {
 		log.Errorf("failed to create msgjson.Message for order response: %v", err)
 		return
 	}
-	r.auth.Send(oRecord.order.User(), respMsg)
-}
\ No newline at end of file
+	r.auth.Send(user, respMsg)
+}
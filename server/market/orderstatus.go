@@ -0,0 +1,144 @@
+// This code is available on the terms of the project LICENSE.md file,
+// also available online at https://blueoakcouncil.org/license/1.0.0.
+
+package market
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/decred/dcrdex/dex/msgjson"
+	"github.com/decred/dcrdex/dex/order"
+	"github.com/decred/dcrdex/server/account"
+)
+
+// maxOrderStatusIDs bounds the number of order IDs a single 'order_status'
+// request may query, so a client reconciling after a reconnect can batch
+// lookups without letting one request fan out unbounded work across every
+// market.
+const maxOrderStatusIDs = 25
+
+// OrderStatusCode enumerates the lifecycle states a MarketTunnel can report
+// for an order via OrderStatus.
+type OrderStatusCode uint8
+
+const (
+	// OrderStatusUnknown is the zero value, and should never be returned by
+	// a well-behaved MarketTunnel.
+	OrderStatusUnknown OrderStatusCode = iota
+	// OrderStatusEpoch indicates the order is in the current epoch queue,
+	// pending matching.
+	OrderStatusEpoch
+	// OrderStatusBooked indicates a standing limit order resting on the
+	// order book.
+	OrderStatusBooked
+	// OrderStatusMatched indicates the order has one or more matches that
+	// have not yet completed settlement.
+	OrderStatusMatched
+	// OrderStatusFilled indicates the order is fully filled.
+	OrderStatusFilled
+	// OrderStatusCanceled indicates the order was canceled before it was
+	// fully filled.
+	OrderStatusCanceled
+)
+
+// OrderStatus is a MarketTunnel's report of an order's current state, for
+// use by the 'order_status' route.
+type OrderStatus struct {
+	ID        order.OrderID
+	AccountID account.AccountID
+	Status    OrderStatusCode
+	// Remaining is the unfilled quantity of the order, in units of the base
+	// asset. It is zero for fully filled or canceled orders.
+	Remaining uint64
+}
+
+// ErrUnknownOrder is returned by MarketTunnel.OrderStatus when the tunnel has
+// no record of the requested order.
+var ErrUnknownOrder = errors.New("unknown order")
+
+// handleOrderStatus is the handler for the 'order_status' route. This route
+// accepts a msgjson.OrderStatusRequest payload listing up to
+// maxOrderStatusIDs order IDs, and returns one msgjson.OrderStatusEntry per
+// ID, in request order. An entry holds either a signed
+// msgjson.OrderStatusResult or a msgjson.Error, so an unknown or malformed ID
+// does not prevent the rest of the request from resolving; a client
+// reconciling after a reconnect gets every status it can in one round trip.
+// The caller is not required to know which market an order belongs to;
+// unknown markets are handled by fanning the lookup out across every
+// configured MarketTunnel. An order owned by a different account is reported
+// as unknown, so this route cannot be used to disclose other users' orders.
+func (r *OrderRouter) handleOrderStatus(user account.AccountID, msg *msgjson.Message) *msgjson.Error {
+	req := new(msgjson.OrderStatusRequest)
+	if err := json.Unmarshal(msg.Payload, req); err != nil {
+		return msgjson.NewError(msgjson.RPCParseError, "error decoding 'order_status' payload")
+	}
+	if len(req.OrderIDs) == 0 {
+		return msgjson.NewError(msgjson.OrderParameterError, "order_status requires at least one order ID")
+	}
+	if len(req.OrderIDs) > maxOrderStatusIDs {
+		return msgjson.NewError(msgjson.OrderParameterError,
+			fmt.Sprintf("order_status accepts at most %d order IDs, got %d", maxOrderStatusIDs, len(req.OrderIDs)))
+	}
+
+	// A single unknown or unauthorized ID must not prevent the client from
+	// learning the status of every other ID in the request; build a
+	// per-entry result/error list instead of aborting the whole batch,
+	// mirroring the msgjson.BatchResult pattern used by the 'batch' route.
+	results := make([]*msgjson.OrderStatusEntry, len(req.OrderIDs))
+	var signables []msgjson.Signable
+	for i, idBytes := range req.OrderIDs {
+		if len(idBytes) != order.OrderIDSize {
+			results[i] = &msgjson.OrderStatusEntry{
+				Error: msgjson.NewError(msgjson.OrderParameterError, "invalid order ID format"),
+			}
+			continue
+		}
+		var oid order.OrderID
+		copy(oid[:], idBytes)
+
+		status, found := r.findOrderStatus(user, oid)
+		if !found {
+			results[i] = &msgjson.OrderStatusEntry{
+				Error: msgjson.NewError(msgjson.UnknownOrderError, fmt.Sprintf("unknown order %s", oid)),
+			}
+			continue
+		}
+		res := &msgjson.OrderStatusResult{
+			OrderID:   oid[:],
+			Status:    uint8(status.Status),
+			Remaining: status.Remaining,
+		}
+		results[i] = &msgjson.OrderStatusEntry{Result: res}
+		signables = append(signables, res)
+	}
+
+	r.auth.Sign(signables...)
+
+	respMsg, err := msgjson.NewResponse(msg.ID, results, nil)
+	if err != nil {
+		log.Errorf("failed to create msgjson.Message for order_status response: %v", err)
+		return msgjson.NewError(msgjson.RPCInternalError, "internal error")
+	}
+	r.auth.Send(user, respMsg)
+	return nil
+}
+
+// findOrderStatus fans out an OrderStatus lookup across every configured
+// MarketTunnel, since the client is not required to know which market an
+// order belongs to. An order found but owned by a different account is
+// reported as not found, to avoid disclosing other users' orders.
+func (r *OrderRouter) findOrderStatus(user account.AccountID, oid order.OrderID) (*OrderStatus, bool) {
+	for _, tunnel := range r.tunnels {
+		status, err := tunnel.OrderStatus(oid)
+		if err != nil {
+			continue
+		}
+		if status.AccountID != user {
+			return nil, false
+		}
+		return status, true
+	}
+	return nil, false
+}
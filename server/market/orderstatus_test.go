@@ -0,0 +1,88 @@
+// This code is available on the terms of the project LICENSE.md file,
+// also available online at https://blueoakcouncil.org/license/1.0.0.
+
+package market
+
+import (
+	"testing"
+
+	"github.com/decred/dcrdex/dex/order"
+)
+
+// statusTunnel is a minimal MarketTunnel that reports a fixed OrderStatus (or
+// ErrUnknownOrder) for a single configured order ID, for exercising
+// findOrderStatus's fan-out across multiple tunnels.
+type statusTunnel struct {
+	fakeTunnel
+	id     order.OrderID
+	status *OrderStatus
+}
+
+func (s *statusTunnel) OrderStatus(oid order.OrderID) (*OrderStatus, error) {
+	if oid != s.id {
+		return nil, ErrUnknownOrder
+	}
+	return s.status, nil
+}
+
+// TestFindOrderStatus_FanOut confirms that findOrderStatus checks every
+// configured tunnel until one recognizes the order, not just the first.
+func TestFindOrderStatus_FanOut(t *testing.T) {
+	user := testAccountID(1)
+	var oid order.OrderID
+	oid[0] = 7
+
+	wantStatus := &OrderStatus{ID: oid, AccountID: user, Status: OrderStatusBooked, Remaining: 500}
+	r := &OrderRouter{
+		tunnels: map[string]MarketTunnel{
+			"dcr_btc": &statusTunnel{id: order.OrderID{}, status: nil},
+			"dcr_ltc": &statusTunnel{id: oid, status: wantStatus},
+		},
+	}
+
+	got, found := r.findOrderStatus(user, oid)
+	if !found {
+		t.Fatal("expected the order to be found in the second tunnel")
+	}
+	if got != wantStatus {
+		t.Fatalf("got status %+v, want %+v", got, wantStatus)
+	}
+}
+
+// TestFindOrderStatus_WrongAccountHiddenAsUnknown confirms that an order
+// found under a different account is reported as not found rather than
+// disclosing another user's order.
+func TestFindOrderStatus_WrongAccountHiddenAsUnknown(t *testing.T) {
+	user := testAccountID(1)
+	otherUser := testAccountID(2)
+	var oid order.OrderID
+	oid[0] = 9
+
+	r := &OrderRouter{
+		tunnels: map[string]MarketTunnel{
+			"dcr_btc": &statusTunnel{id: oid, status: &OrderStatus{ID: oid, AccountID: otherUser, Status: OrderStatusBooked}},
+		},
+	}
+
+	if _, found := r.findOrderStatus(user, oid); found {
+		t.Fatal("expected an order owned by a different account to be reported as not found")
+	}
+}
+
+// TestFindOrderStatus_Unknown confirms that an order no tunnel recognizes is
+// reported as not found.
+func TestFindOrderStatus_Unknown(t *testing.T) {
+	user := testAccountID(1)
+	var oid order.OrderID
+	oid[0] = 1
+
+	r := &OrderRouter{
+		tunnels: map[string]MarketTunnel{
+			"dcr_btc": &statusTunnel{id: order.OrderID{99}, status: &OrderStatus{}},
+		},
+	}
+
+	if _, found := r.findOrderStatus(user, oid); found {
+		t.Fatal("expected an order no tunnel recognizes to be reported as not found")
+	}
+}
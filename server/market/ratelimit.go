@@ -0,0 +1,163 @@
+// This code is available on the terms of the project LICENSE.md file,
+// also available online at https://blueoakcouncil.org/license/1.0.0.
+
+package market
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/decred/dcrdex/dex/msgjson"
+	"github.com/decred/dcrdex/server/account"
+)
+
+const (
+	// limiterIdleTimeout is how long an account's limiters may sit unused
+	// before the janitor evicts them.
+	limiterIdleTimeout = 10 * time.Minute
+	// limiterJanitorInterval is how often the janitor sweeps for idle
+	// per-account limiters.
+	limiterJanitorInterval = time.Minute
+)
+
+// acctLimiter holds the token-bucket limiters for a single account's order
+// and cancel submissions, plus the last time either was used so the janitor
+// knows when the entry has gone idle.
+type acctLimiter struct {
+	orders   *rate.Limiter
+	cancels  *rate.Limiter
+	lastUsed time.Time
+}
+
+// orderRateLimiter enforces a per-account token-bucket limit on order and
+// cancel submissions. This bounds the amount of expensive UTXO validation
+// work a single authenticated user can force the server to perform.
+type orderRateLimiter struct {
+	ordersPerSecond  rate.Limit
+	orderBurst       int
+	cancelsPerSecond rate.Limit
+	cancelBurst      int
+
+	mtx      sync.Mutex
+	limiters map[account.AccountID]*acctLimiter
+
+	admitted uint64 // atomic
+	limited  uint64 // atomic
+}
+
+// newOrderRateLimiter is the constructor for an orderRateLimiter. A
+// non-positive ordersPerSecond and cancelsPerSecond independently disable
+// limiting for orders and cancels, respectively.
+func newOrderRateLimiter(ordersPerSecond float64, orderBurst int, cancelsPerSecond float64, cancelBurst int) *orderRateLimiter {
+	return &orderRateLimiter{
+		ordersPerSecond:  rate.Limit(ordersPerSecond),
+		orderBurst:       orderBurst,
+		cancelsPerSecond: rate.Limit(cancelsPerSecond),
+		cancelBurst:      cancelBurst,
+		limiters:         make(map[account.AccountID]*acctLimiter),
+	}
+}
+
+// acct fetches, or lazily creates, the limiter pair for an account.
+func (l *orderRateLimiter) acct(user account.AccountID) *acctLimiter {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	a, found := l.limiters[user]
+	if !found {
+		a = &acctLimiter{
+			orders:  rate.NewLimiter(l.ordersPerSecond, l.orderBurst),
+			cancels: rate.NewLimiter(l.cancelsPerSecond, l.cancelBurst),
+		}
+		l.limiters[user] = a
+	}
+	a.lastUsed = time.Now()
+	return a
+}
+
+// checkOrder enforces the order-submission limit for user, returning a
+// msgjson.RateLimitError if the limit is exceeded. A non-positive
+// ordersPerSecond disables the check.
+func (l *orderRateLimiter) checkOrder(user account.AccountID) *msgjson.Error {
+	return l.checkOrderN(user, 1)
+}
+
+// checkOrderN enforces the order-submission limit for user, charging it n
+// tokens in one atomic reservation rather than n independent ones. A
+// multi-order submission like an oco pair must use this instead of calling
+// checkOrder n times, since with sequential single-token reservations the
+// first can succeed and spend its token while a later one is rejected,
+// leaving the bucket partially spent by a request that was refused. A
+// rejected reservation here spends no tokens; tokens of an admitted
+// reservation are not returned if the caller later rejects the request.
+// A non-positive ordersPerSecond disables the check.
+func (l *orderRateLimiter) checkOrderN(user account.AccountID, n int) *msgjson.Error {
+	if l.ordersPerSecond <= 0 {
+		return nil
+	}
+	a := l.acct(user)
+	return l.reserveN(a.orders, n)
+}
+
+// checkCancel enforces the cancel-submission limit for user, returning a
+// msgjson.RateLimitError if the limit is exceeded. A non-positive
+// cancelsPerSecond disables the check.
+func (l *orderRateLimiter) checkCancel(user account.AccountID) *msgjson.Error {
+	if l.cancelsPerSecond <= 0 {
+		return nil
+	}
+	a := l.acct(user)
+	return l.reserveN(a.cancels, 1)
+}
+
+// reserveN takes a single atomic reservation of n tokens from limiter and, if
+// it would require waiting, cancels the reservation and returns an error
+// instead of blocking the caller. The wait is carried on the returned
+// msgjson.Error's RetryAfter field, not just embedded in Message, so a client
+// can back off programmatically rather than parsing the message text.
+func (l *orderRateLimiter) reserveN(limiter *rate.Limiter, n int) *msgjson.Error {
+	res := limiter.ReserveN(time.Now(), n)
+	if delay := res.Delay(); delay > 0 {
+		res.Cancel()
+		atomic.AddUint64(&l.limited, 1)
+		return &msgjson.Error{
+			Code:       msgjson.RateLimitError,
+			Message:    fmt.Sprintf("rate limit exceeded, retry after %s", delay.Round(time.Millisecond)),
+			RetryAfter: delay.Round(time.Millisecond),
+		}
+	}
+	atomic.AddUint64(&l.admitted, 1)
+	return nil
+}
+
+// Metrics returns the running totals of admitted and rate-limited requests.
+func (l *orderRateLimiter) Metrics() (admitted, limited uint64) {
+	return atomic.LoadUint64(&l.admitted), atomic.LoadUint64(&l.limited)
+}
+
+// runJanitor periodically evicts per-account limiters that have been idle
+// for longer than limiterIdleTimeout, bounding the limiter map's memory use.
+// It blocks until ctx is canceled.
+func (l *orderRateLimiter) runJanitor(ctx context.Context) {
+	ticker := time.NewTicker(limiterJanitorInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			cutoff := time.Now().Add(-limiterIdleTimeout)
+			l.mtx.Lock()
+			for user, a := range l.limiters {
+				if a.lastUsed.Before(cutoff) {
+					delete(l.limiters, user)
+				}
+			}
+			l.mtx.Unlock()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,92 @@
+// This code is available on the terms of the project LICENSE.md file,
+// also available online at https://blueoakcouncil.org/license/1.0.0.
+
+package market
+
+import (
+	"testing"
+
+	"github.com/decred/dcrdex/dex/msgjson"
+	"github.com/decred/dcrdex/server/account"
+)
+
+func testAccountID(b byte) (acct account.AccountID) {
+	acct[0] = b
+	return acct
+}
+
+// TestOrderRateLimiter_Burst confirms that checkOrder admits up to the
+// configured burst and then rejects with a RateLimitError, and that cancels
+// are tracked in an independent bucket from orders.
+func TestOrderRateLimiter_Burst(t *testing.T) {
+	// A tiny positive rate keeps limiting enabled while refilling too slowly
+	// to matter during the test.
+	l := newOrderRateLimiter(0.001, 2, 0.001, 1)
+	user := testAccountID(1)
+
+	if rpcErr := l.checkOrder(user); rpcErr != nil {
+		t.Fatalf("unexpected rejection of 1st order in burst: %v", rpcErr)
+	}
+	if rpcErr := l.checkOrder(user); rpcErr != nil {
+		t.Fatalf("unexpected rejection of 2nd order in burst: %v", rpcErr)
+	}
+	rpcErr := l.checkOrder(user)
+	if rpcErr == nil {
+		t.Fatal("expected 3rd order to exceed burst, got nil error")
+	}
+	if rpcErr.Code != msgjson.RateLimitError {
+		t.Fatalf("expected RateLimitError, got code %d", rpcErr.Code)
+	}
+	if rpcErr.RetryAfter <= 0 {
+		t.Fatal("expected a positive RetryAfter on a rate-limited error")
+	}
+
+	// Cancels are a separate bucket and should be unaffected by the
+	// exhausted order bucket.
+	if rpcErr := l.checkCancel(user); rpcErr != nil {
+		t.Fatalf("unexpected rejection of cancel sharing no bucket with orders: %v", rpcErr)
+	}
+	if rpcErr := l.checkCancel(user); rpcErr == nil {
+		t.Fatal("expected 2nd cancel to exceed its burst of 1")
+	}
+}
+
+// TestOrderRateLimiter_CheckOrderNAtomic confirms that checkOrderN either
+// admits the full request or rejects it outright, never partially spending
+// the bucket for a multi-unit request like an oco pair's two legs.
+func TestOrderRateLimiter_CheckOrderNAtomic(t *testing.T) {
+	l := newOrderRateLimiter(0.001, 2, 0, 0)
+	user := testAccountID(2)
+
+	if rpcErr := l.checkOrderN(user, 2); rpcErr != nil {
+		t.Fatalf("unexpected rejection of a 2-unit reservation exactly at burst: %v", rpcErr)
+	}
+	if rpcErr := l.checkOrder(user); rpcErr == nil {
+		t.Fatal("expected the bucket to be fully spent after the 2-unit reservation")
+	}
+
+	l2 := newOrderRateLimiter(0.001, 1, 0, 0)
+	user2 := testAccountID(3)
+	if rpcErr := l2.checkOrderN(user2, 2); rpcErr == nil {
+		t.Fatal("expected a 2-unit reservation to be rejected outright against a burst of 1")
+	}
+	// The rejected reservation must not have consumed the single token.
+	if rpcErr := l2.checkOrder(user2); rpcErr != nil {
+		t.Fatalf("a rejected multi-unit reservation left the bucket partially spent: %v", rpcErr)
+	}
+}
+
+// TestOrderRateLimiter_Disabled confirms that a non-positive rate disables
+// limiting entirely, independent of the configured burst.
+func TestOrderRateLimiter_Disabled(t *testing.T) {
+	l := newOrderRateLimiter(0, 0, 0, 0)
+	user := testAccountID(4)
+	for i := 0; i < 5; i++ {
+		if rpcErr := l.checkOrder(user); rpcErr != nil {
+			t.Fatalf("order rate limiting should be disabled, got error on iteration %d: %v", i, rpcErr)
+		}
+		if rpcErr := l.checkCancel(user); rpcErr != nil {
+			t.Fatalf("cancel rate limiting should be disabled, got error on iteration %d: %v", i, rpcErr)
+		}
+	}
+}